@@ -2,11 +2,14 @@ package api
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"ipchess/p2p"
 	"net/http"
 
 	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +23,32 @@ func WithLogger(logger *zap.Logger) Option {
 
 var wsUpgrader websocket.Upgrader
 
+// matchClockResult is the JSON shape returned by the match_clock RPC
+// method.
+type matchClockResult struct {
+	White int64  `json:"white"`
+	Black int64  `json:"black"`
+	Turn  string `json:"turn"`
+}
+
+// errNotAllStrings is returned by stringSlice when raw contains a
+// non-string element.
+var errNotAllStrings = errors.New("not all elements are strings")
+
+// stringSlice converts a decoded JSON array param to a []string, failing
+// if any element isn't a string.
+func stringSlice(raw []interface{}) ([]string, error) {
+	out := make([]string, len(raw))
+	for i, v := range raw {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errNotAllStrings
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
 // Handler handles API RPC requests.
 type Handler struct {
 	ctx       context.Context
@@ -78,22 +107,92 @@ func (h *Handler) Shutdown() {
 	h.server.Shutdown(context.Background())
 }
 
+// pushSpectatedMoves forwards every move of a match_spectate subscription as
+// a "match_spectate" notification, until ctx is done or the spectator stream
+// ends.
+func (h *Handler) pushSpectatedMoves(ctx context.Context, notificationChan chan<- *jsonRPCNotification, spectator *p2p.Spectator) {
+	defer spectator.Close()
+
+	moveChan, errChan := spectator.Moves(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errChan:
+			h.logger.Debug("spectator subscription ended", zap.Error(err))
+			return
+		case move, ok := <-moveChan:
+			if !ok {
+				return
+			}
+			notificationChan <- &jsonRPCNotification{
+				Method: "match_spectate",
+				Params: move,
+			}
+		}
+	}
+}
+
+// pushLobbyOffers forwards every open challenge received on a
+// lobby_subscribe subscription as a "lobby_subscribe" notification, until
+// ctx is done or offerChan is closed.
+func (h *Handler) pushLobbyOffers(ctx context.Context, notificationChan chan<- *jsonRPCNotification, offerChan <-chan p2p.ChallengeOffer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case offer, ok := <-offerChan:
+			if !ok {
+				return
+			}
+			notificationChan <- &jsonRPCNotification{
+				Method: "lobby_subscribe",
+				Params: offer,
+			}
+		}
+	}
+}
+
+// pushCapabilityChanges forwards every CapabilityChange received on a
+// capability_subscribe subscription as a "capability_subscribe"
+// notification, until ctx is done or changeChan is closed.
+func (h *Handler) pushCapabilityChanges(ctx context.Context, notificationChan chan<- *jsonRPCNotification, changeChan <-chan p2p.CapabilityChange) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changeChan:
+			if !ok {
+				return
+			}
+			notificationChan <- &jsonRPCNotification{
+				Method: "capability_subscribe",
+				Params: change,
+			}
+		}
+	}
+}
+
 func (h *Handler) handleConn(conn *websocket.Conn) {
 	defer conn.Close()
 
 	responseHandlerCtx, cancelResponseHandlerCtx := context.WithCancel(h.ctx)
 	defer cancelResponseHandlerCtx()
 	responseChan := make(chan *jsonRPCResponse)
+	notificationChan := make(chan *jsonRPCNotification)
 	go func() {
 		for {
 			select {
 			case <-responseHandlerCtx.Done():
 				return
 			case response := <-responseChan:
-				err := writeJSONRPCResponse(conn, *response)
-				if err != nil {
+				if err := writeJSONRPCResponse(conn, *response); err != nil {
 					h.logger.Debug("failed sending JSONRPC response", zap.Error(err))
 				}
+			case notification := <-notificationChan:
+				if err := writeJSONRPCNotification(conn, *notification); err != nil {
+					h.logger.Debug("failed sending JSONRPC notification", zap.Error(err))
+				}
 			}
 		}
 	}()
@@ -119,6 +218,13 @@ func (h *Handler) handleConn(conn *websocket.Conn) {
 				},
 			}
 		} else {
+			reqCtx := p2p.WithLogger(responseHandlerCtx, h.logger.With(
+				zap.String("rpcID", rpcIDString(request.ID)),
+				zap.String("method", request.Method),
+			))
+			logger := p2p.LoggerFromContext(reqCtx)
+			logger.Debug("handling JSONRPC request")
+
 			switch request.Method {
 			case "node_id":
 				responseChan <- &jsonRPCResponse{
@@ -126,6 +232,217 @@ func (h *Handler) handleConn(conn *websocket.Conn) {
 					ID:     request.ID,
 				}
 
+			case "match_spectate":
+				params, ok := request.Params.(map[string]interface{})
+				matchIDHex, _ := params["matchId"].(string)
+				peerIDStr, _ := params["peerId"].(string)
+				if !ok || matchIDHex == "" || peerIDStr == "" {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				matchIDBytes, err := hex.DecodeString(matchIDHex)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+				var matchID p2p.MatchID
+				copy(matchID[:], matchIDBytes)
+
+				peerID, err := peer.Decode(peerIDStr)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				spectator, err := h.host.Spectate(reqCtx, matchID, peerID)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: &jsonRPCResponseError{
+							Code:    -32000,
+							Message: err.Error(),
+						},
+						ID: request.ID,
+					}
+					break
+				}
+
+				responseChan <- &jsonRPCResponse{
+					Result: "subscribed",
+					ID:     request.ID,
+				}
+				go h.pushSpectatedMoves(reqCtx, notificationChan, spectator)
+
+			case "lobby_list":
+				responseChan <- &jsonRPCResponse{
+					Result: h.host.ListOpenChallenges(),
+					ID:     request.ID,
+				}
+
+			case "lobby_subscribe":
+				offerChan, err := h.host.SubscribeLobby(reqCtx)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: &jsonRPCResponseError{
+							Code:    -32000,
+							Message: err.Error(),
+						},
+						ID: request.ID,
+					}
+					break
+				}
+
+				responseChan <- &jsonRPCResponse{
+					Result: "subscribed",
+					ID:     request.ID,
+				}
+				go h.pushLobbyOffers(reqCtx, notificationChan, offerChan)
+
+			case "peer_capabilities":
+				params, ok := request.Params.(map[string]interface{})
+				peerIDStr, _ := params["peerId"].(string)
+				if !ok || peerIDStr == "" {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				peerID, err := peer.Decode(peerIDStr)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				caps, ok := h.host.PeerCapabilities(peerID)
+				if !ok {
+					responseChan <- &jsonRPCResponse{
+						Error: &jsonRPCResponseError{
+							Code:    -32000,
+							Message: "no known capabilities for peer",
+						},
+						ID: request.ID,
+					}
+					break
+				}
+
+				responseChan <- &jsonRPCResponse{
+					Result: caps,
+					ID:     request.ID,
+				}
+
+			case "match_clock":
+				params, ok := request.Params.(map[string]interface{})
+				matchIDHex, _ := params["matchId"].(string)
+				if !ok || matchIDHex == "" {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				matchIDBytes, err := hex.DecodeString(matchIDHex)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+				var matchID p2p.MatchID
+				copy(matchID[:], matchIDBytes)
+
+				match, ok := h.host.Match(matchID)
+				if !ok {
+					responseChan <- &jsonRPCResponse{
+						Error: &jsonRPCResponseError{
+							Code:    -32000,
+							Message: "no known match with that ID",
+						},
+						ID: request.ID,
+					}
+					break
+				}
+
+				white, black, turn := match.ClockState()
+				responseChan <- &jsonRPCResponse{
+					Result: matchClockResult{
+						White: white.Milliseconds(),
+						Black: black.Milliseconds(),
+						Turn:  turn.Pretty(),
+					},
+					ID: request.ID,
+				}
+
+			case "capability_push":
+				params, ok := request.Params.(map[string]interface{})
+				peerIDStr, _ := params["peerId"].(string)
+				featuresRaw, _ := params["features"].([]interface{})
+				if !ok || peerIDStr == "" || len(featuresRaw) == 0 {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				features, err := stringSlice(featuresRaw)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				peerID, err := peer.Decode(peerIDStr)
+				if err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: invalidRequestError(),
+						ID:    request.ID,
+					}
+					break
+				}
+
+				if err := h.host.PushCapabilities(reqCtx, peerID, features); err != nil {
+					responseChan <- &jsonRPCResponse{
+						Error: &jsonRPCResponseError{
+							Code:    -32000,
+							Message: err.Error(),
+						},
+						ID: request.ID,
+					}
+					break
+				}
+
+				responseChan <- &jsonRPCResponse{
+					Result: "pushed",
+					ID:     request.ID,
+				}
+
+			case "capability_subscribe":
+				changeChan := h.host.SubscribeCapabilities(reqCtx)
+
+				responseChan <- &jsonRPCResponse{
+					Result: "subscribed",
+					ID:     request.ID,
+				}
+				go h.pushCapabilityChanges(reqCtx, notificationChan, changeChan)
+
 			default:
 				responseChan <- &jsonRPCResponse{
 					Error: &jsonRPCResponseError{
@@ -55,6 +55,15 @@ type jsonRPCResponse struct {
 	ID      *json.Number          `json:"id,omitempty"`
 }
 
+// jsonRPCNotification represents a server-initiated JSONRPC message, used to
+// push subscription updates (e.g. match_spectate) that are not a response to
+// any particular request ID.
+type jsonRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
 // invalidRequestError constructs a new JSONRPC invalid request error.
 func invalidRequestError() *jsonRPCResponseError {
 	return &jsonRPCResponseError{
@@ -68,3 +77,18 @@ func writeJSONRPCResponse(conn *websocket.Conn, response jsonRPCResponse) error
 	response.JSONRPC = "2.0"
 	return conn.WriteJSON(response)
 }
+
+// writeJSONRPCNotification writes a JSONRPC notification to a Websocket stream.
+func writeJSONRPCNotification(conn *websocket.Conn, notification jsonRPCNotification) error {
+	notification.JSONRPC = "2.0"
+	return conn.WriteJSON(notification)
+}
+
+// rpcIDString renders a request ID for use as a logging key, since it may be
+// absent on notifications.
+func rpcIDString(id *json.Number) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
@@ -58,7 +58,7 @@ func NewDaemonCmd() *cobra.Command {
 				ctxCancel()
 			}()
 
-			h := p2p.NewHost(p2p.WithLogger(logger))
+			h := p2p.NewHost(p2p.WithHostLogger(logger))
 			if err := h.Start(ctx); err != nil {
 				panic(err)
 			}
@@ -28,7 +28,7 @@ func main() {
 		ctxCancel()
 	}()
 
-	h := p2p.NewHost(p2p.WithLogger(logger))
+	h := p2p.NewHost(p2p.WithHostLogger(logger))
 	if err := h.Start(ctx); err != nil {
 		panic(err)
 	}
@@ -28,58 +28,78 @@ var (
 	DeclinedByPeer           ChallengeDeclinedReason = "peer declined the challenge"
 	InvalidRandomBytesLength ChallengeDeclinedReason = "received more than one random byte from peer"
 	CommitmentMismatch       ChallengeDeclinedReason = "peer preimage does not match commitment"
+	TimeControlMismatch      ChallengeDeclinedReason = "peers require different time controls"
 )
 
 // challenge initiates or handles match requests to and from peers.
-type challenge struct {
-	logger *zap.Logger
-}
+type challenge struct{}
 
-func newChallenge(logger *zap.Logger) *challenge {
-	return &challenge{
-		logger: logger,
-	}
+func newChallenge() *challenge {
+	return &challenge{}
 }
 
 func (c *challenge) Ask(ctx context.Context, stream network.Stream) (bool, error) {
 	return false, nil
 }
 
-// Initiate challenges a peer to a match.
-func (c *challenge) Initiate(ctx context.Context, stream network.Stream) (*MatchInfo, error) {
-	c.logger.Debug("generating challenge ask random bytes")
+// Initiate challenges a peer to a match, negotiating opts' TimeControl with
+// the peer's own preference. peerSupportsClock is the peer's own
+// advertised clock support, already known from its Hello, used to decline
+// a TimeControl the peer couldn't honor instead of racing it through the
+// rest of the handshake.
+func (c *challenge) Initiate(ctx context.Context, stream network.Stream, opts *matchOptions, peerSupportsClock bool) (*MatchInfo, error) {
+	logger := LoggerFromContext(ctx)
+
+	logger.Debug("generating challenge ask random bytes")
 	rb := make([]byte, 32)
 	if _, err := rand.Read(rb); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("generating challenge challenge ask commitment")
+	logger.Debug("generating challenge challenge ask commitment")
 	commitment, err := multihash.Encode(rb, multihash.SHA2_256)
 	if err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("sending challenge ask")
+	logger.Debug("sending challenge ask")
 	challengeAsk := &ipchessproto.ChallengeAsk{
-		Commitment: commitment,
+		Commitment:  commitment,
+		TimeControl: timeControlToProto(opts.timeControl),
 	}
 	if err := sendMessage(ctx, stream, challengeAsk); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("waiting challenge ask response")
+	logger.Debug("waiting challenge ask response")
 	var challengeAskResponse ipchessproto.ChallengeAskResponse
 	if err := receiveMessage(ctx, stream, &challengeAskResponse); err != nil {
 		return nil, err
 	}
 
+	timeControl, err := negotiateTimeControl(opts.timeControl, timeControlFromProto(challengeAskResponse.TimeControl), peerSupportsClock)
+	if err != nil {
+		// When our own capability check, rather than a value mismatch the
+		// peer would also have detected and declined itself, is what's
+		// declining this, the peer already sent this response believing the
+		// match could proceed and is now waiting on our commitment
+		// preimage. Send preimage bytes that are
+		// guaranteed not to satisfy its commitment check instead of
+		// abandoning the stream, so its blocking read resolves as an
+		// ordinary CommitmentMismatch decline rather than hanging forever.
+		if sendErr := sendMessage(ctx, stream, &ipchessproto.ChallengeCommitmentPreimage{Preimage: make([]byte, 32)}); sendErr != nil {
+			logger.Debug("failed sending decline preimage", zap.Error(sendErr))
+		}
+		return nil, err
+	}
+
 	if len(challengeAskResponse.RandomBytes) == 0 {
 		return nil, &ChallengeDeclinedError{Reason: DeclinedByPeer}
 	} else if len(challengeAskResponse.RandomBytes) != 32 {
 		return nil, &ChallengeDeclinedError{Reason: InvalidRandomBytesLength}
 	}
 
-	c.logger.Debug("sending challenge commitment preimage")
+	logger.Debug("sending challenge commitment preimage")
 	commitmentPreimage := &ipchessproto.ChallengeCommitmentPreimage{
 		Preimage: rb,
 	}
@@ -87,7 +107,7 @@ func (c *challenge) Initiate(ctx context.Context, stream network.Stream) (*Match
 		return nil, err
 	}
 
-	m := &MatchInfo{}
+	m := &MatchInfo{TimeControl: timeControl}
 
 	for i := 0; i < 32; i++ {
 		m.ID[i] = rb[i] ^ challengeAskResponse.RandomBytes[i]
@@ -104,35 +124,53 @@ func (c *challenge) Initiate(ctx context.Context, stream network.Stream) (*Match
 	return m, nil
 }
 
-// Handle handles an incoming match challenge from a peer.
-func (c *challenge) Handle(ctx context.Context, stream network.Stream) (*MatchInfo, error) {
-	c.logger.Debug("waiting challenge request")
+// Handle handles an incoming match challenge from a peer, negotiating opts'
+// TimeControl with the peer's own preference. peerSupportsClock is the
+// peer's own advertised clock support, already known from its Hello, used
+// to decline a TimeControl the peer couldn't honor instead of racing it
+// through the rest of the handshake.
+func (c *challenge) Handle(ctx context.Context, stream network.Stream, opts *matchOptions, peerSupportsClock bool) (*MatchInfo, error) {
+	logger := LoggerFromContext(ctx)
+
+	logger.Debug("waiting challenge request")
 	var challengeAsk ipchessproto.ChallengeAsk
 	if err := receiveMessage(ctx, stream, &challengeAsk); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("generating challenge response random bytes")
+	timeControl, err := negotiateTimeControl(opts.timeControl, timeControlFromProto(challengeAsk.TimeControl), peerSupportsClock)
+	if err != nil {
+		logger.Debug("declining challenge, time control mismatch")
+		if sendErr := sendMessage(ctx, stream, &ipchessproto.ChallengeAskResponse{
+			TimeControl: timeControlToProto(opts.timeControl),
+		}); sendErr != nil {
+			logger.Debug("failed sending time control mismatch decline", zap.Error(sendErr))
+		}
+		return nil, err
+	}
+
+	logger.Debug("generating challenge response random bytes")
 	rb := make([]byte, 32)
 	if _, err := rand.Read(rb); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("sending challenge response")
+	logger.Debug("sending challenge response")
 	challengeAskResponse := &ipchessproto.ChallengeAskResponse{
 		RandomBytes: rb,
+		TimeControl: timeControlToProto(&timeControl),
 	}
 	if err := sendMessage(ctx, stream, challengeAskResponse); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("waiting challenge commitment preimage")
+	logger.Debug("waiting challenge commitment preimage")
 	var commitmentPreimage ipchessproto.ChallengeCommitmentPreimage
 	if err := receiveMessage(ctx, stream, &commitmentPreimage); err != nil {
 		return nil, err
 	}
 
-	c.logger.Debug("checking piece color negotiation preimage")
+	logger.Debug("checking piece color negotiation preimage")
 	hashedPreimage, err := multihash.Encode(commitmentPreimage.Preimage, multihash.SHA2_256)
 	if err != nil {
 		return nil, err
@@ -142,7 +180,7 @@ func (c *challenge) Handle(ctx context.Context, stream network.Stream) (*MatchIn
 		return nil, &ChallengeDeclinedError{Reason: CommitmentMismatch}
 	}
 
-	m := &MatchInfo{}
+	m := &MatchInfo{TimeControl: timeControl}
 
 	for i := 0; i < 32; i++ {
 		m.ID[i] = rb[i] ^ commitmentPreimage.Preimage[i]
@@ -0,0 +1,242 @@
+package p2p
+
+import (
+	"errors"
+	"ipchess/gen/ipchessproto"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	errImplausibleClockReading = errors.New("move reports more remaining time than elapsed time and increment allow")
+	errFlagFallen              = errors.New("move reports negative remaining time")
+)
+
+// clockFeature is the Capabilities feature flag advertised by Hosts that
+// support time-control matches. negotiateTimeControl checks it so that a
+// TimeControl requirement is declined when the peer can't honor it at
+// all, instead of the peer silently treating an unrecognized requirement
+// as "no preference".
+const clockFeature = "clock"
+
+// TimeControlKind identifies which clock algorithm governs a Match's
+// increment after each move.
+type TimeControlKind string
+
+const (
+	// TimeControlBaseIncrement adds Increment to the mover's clock after
+	// every move, regardless of how much time the move actually took.
+	TimeControlBaseIncrement TimeControlKind = "base_increment"
+	// TimeControlFischer is an alias for TimeControlBaseIncrement under
+	// the name most players know it by.
+	TimeControlFischer TimeControlKind = "fischer"
+	// TimeControlBronstein refunds the time a move took, up to Increment,
+	// so a side can never bank more total time than it had before moving.
+	TimeControlBronstein TimeControlKind = "bronstein"
+)
+
+// TimeControl describes a chess clock: the time Base each side starts
+// with, and the Increment credited after each move according to Kind. The
+// zero value (empty Kind) means the match is untimed.
+type TimeControl struct {
+	Kind      TimeControlKind
+	Base      time.Duration
+	Increment time.Duration
+}
+
+// matchOptions holds the optional parameters negotiated as part of a
+// challenge.
+type matchOptions struct {
+	timeControl *TimeControl
+}
+
+// MatchOption configures optional match parameters negotiated during
+// challenge initiation, e.g. via Host.ChallengePeer or Host.Accept.
+type MatchOption func(*matchOptions)
+
+// WithTimeControl requires tc as the match's time control. If the other
+// side also requires a time control and it differs, the challenge is
+// declined with TimeControlMismatch; if only one side specifies one, that
+// one is used.
+func WithTimeControl(tc TimeControl) MatchOption {
+	return func(o *matchOptions) {
+		o.timeControl = &tc
+	}
+}
+
+func newMatchOptions(options ...MatchOption) *matchOptions {
+	o := &matchOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// negotiateTimeControl resolves a match's TimeControl from each side's
+// preference. An unset preference (nil) defers to the other side's; two
+// incompatible preferences are rejected rather than one silently winning.
+// A local preference paired with a peer that doesn't advertise clock
+// support is also rejected: an older peer may not understand time
+// control at all, rather than simply disagreeing on its value, so the
+// declination is indistinguishable from an ordinary mismatch to callers.
+func negotiateTimeControl(local, remote *TimeControl, peerSupportsClock bool) (TimeControl, error) {
+	if local != nil && !peerSupportsClock {
+		return TimeControl{}, &ChallengeDeclinedError{Reason: TimeControlMismatch}
+	}
+
+	switch {
+	case local == nil && remote == nil:
+		return TimeControl{}, nil
+	case local == nil:
+		return *remote, nil
+	case remote == nil:
+		return *local, nil
+	case *local == *remote:
+		return *local, nil
+	default:
+		return TimeControl{}, &ChallengeDeclinedError{Reason: TimeControlMismatch}
+	}
+}
+
+// timeControlToProto converts tc to its wire representation, returning nil
+// for an unset preference.
+func timeControlToProto(tc *TimeControl) *ipchessproto.TimeControl {
+	if tc == nil {
+		return nil
+	}
+	return &ipchessproto.TimeControl{
+		Kind:            string(tc.Kind),
+		BaseMillis:      tc.Base.Milliseconds(),
+		IncrementMillis: tc.Increment.Milliseconds(),
+	}
+}
+
+// timeControlFromProto converts msg to a TimeControl, returning nil if msg
+// was not set.
+func timeControlFromProto(msg *ipchessproto.TimeControl) *TimeControl {
+	if msg == nil {
+		return nil
+	}
+	return &TimeControl{
+		Kind:      TimeControlKind(msg.Kind),
+		Base:      time.Duration(msg.BaseMillis) * time.Millisecond,
+		Increment: time.Duration(msg.IncrementMillis) * time.Millisecond,
+	}
+}
+
+// ClockState returns each side's remaining time and whose turn it is,
+// projecting time spent on the current turn even though neither side has
+// reported a move for it yet, so a flag fall can be detected locally
+// without waiting on or trusting the other side.
+func (m *Match) ClockState() (white, black time.Duration, turn peer.ID) {
+	m.clockLock.Lock()
+	defer m.clockLock.Unlock()
+
+	white, black, turn = m.whiteRemaining, m.blackRemaining, m.turn
+	if m.info.TimeControl.Kind == "" || m.turnStartedAt.IsZero() {
+		return white, black, turn
+	}
+
+	elapsed := time.Since(m.turnStartedAt)
+	if turn == m.info.White {
+		white = clampDuration(white - elapsed)
+	} else {
+		black = clampDuration(black - elapsed)
+	}
+	return white, black, turn
+}
+
+// localRemainingAfterMove computes the local side's own remaining time if
+// it moved right now, without mutating the clock. SendMove signs this
+// value and then applies it via recordMove once the send succeeds.
+func (m *Match) localRemainingAfterMove() time.Duration {
+	m.clockLock.Lock()
+	defer m.clockLock.Unlock()
+
+	if m.info.TimeControl.Kind == "" {
+		return 0
+	}
+
+	localPeer := m.stream.Conn().LocalPeer()
+	remaining := m.blackRemaining
+	if localPeer == m.info.White {
+		remaining = m.whiteRemaining
+	}
+
+	elapsed := clampDuration(time.Since(m.turnStartedAt))
+	remaining = clampDuration(remaining-elapsed) + m.incrementForLocked(elapsed)
+	return remaining
+}
+
+// recordMove advances the clock for a completed move: the reported
+// remaining time replaces the mover's previous remaining time and the
+// turn passes to the other side. reportedRemaining is what the mover
+// itself signed as its remaining time after moving; if it is implausibly
+// larger than the elapsed time and increment allow, or negative, the move
+// is rejected instead of trusted.
+//
+// at is the local recorder's own wall-clock time for this move, not the
+// mover's self-reported timestamp: a dishonest mover could otherwise sign a
+// movedAt that never advances, making elapsed ~0 on every move and letting
+// its clock run forever regardless of how much real time passed. Using our
+// own clock to bound elapsed keeps flag falls detectable without trusting
+// the peer, even though the mover's reported timestamp is still carried on
+// the wire for display and history.
+func (m *Match) recordMove(mover peer.ID, reportedRemaining time.Duration, at time.Time) error {
+	m.clockLock.Lock()
+	defer m.clockLock.Unlock()
+
+	if m.info.TimeControl.Kind == "" {
+		return nil
+	}
+
+	previous := &m.blackRemaining
+	if mover == m.info.White {
+		previous = &m.whiteRemaining
+	}
+
+	elapsed := clampDuration(at.Sub(m.turnStartedAt))
+	maxAllowed := clampDuration(*previous-elapsed) + m.incrementForLocked(elapsed)
+
+	if reportedRemaining < 0 {
+		return errFlagFallen
+	}
+	if reportedRemaining > maxAllowed {
+		return errImplausibleClockReading
+	}
+
+	*previous = reportedRemaining
+	if mover == m.info.White {
+		m.turn = m.info.Black
+	} else {
+		m.turn = m.info.White
+	}
+	m.turnStartedAt = time.Now()
+
+	return nil
+}
+
+// incrementForLocked returns the time credited to a mover who just spent
+// elapsed on their move, according to the match's TimeControl.Kind. Callers
+// must hold m.clockLock.
+func (m *Match) incrementForLocked(elapsed time.Duration) time.Duration {
+	switch m.info.TimeControl.Kind {
+	case TimeControlBaseIncrement, TimeControlFischer:
+		return m.info.TimeControl.Increment
+	case TimeControlBronstein:
+		if elapsed < m.info.TimeControl.Increment {
+			return elapsed
+		}
+		return m.info.TimeControl.Increment
+	default:
+		return 0
+	}
+}
+
+func clampDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,149 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestNegotiateTimeControl(t *testing.T) {
+	blitz := TimeControl{Kind: TimeControlFischer, Base: 5 * time.Minute, Increment: 3 * time.Second}
+	rapid := TimeControl{Kind: TimeControlFischer, Base: 15 * time.Minute, Increment: 10 * time.Second}
+
+	cases := []struct {
+		name              string
+		local             *TimeControl
+		remote            *TimeControl
+		peerSupportsClock bool
+		want              TimeControl
+		wantErr           bool
+	}{
+		{name: "both unset", local: nil, remote: nil, peerSupportsClock: true, want: TimeControl{}},
+		{name: "only local set", local: &blitz, remote: nil, peerSupportsClock: true, want: blitz},
+		{name: "only remote set", local: nil, remote: &blitz, peerSupportsClock: true, want: blitz},
+		{name: "both set and equal", local: &blitz, remote: &blitz, peerSupportsClock: true, want: blitz},
+		{name: "both set and mismatched", local: &blitz, remote: &rapid, peerSupportsClock: true, wantErr: true},
+		{name: "local set but peer lacks clock support", local: &blitz, remote: &blitz, peerSupportsClock: false, wantErr: true},
+		{name: "local unset, peer lacks clock support is irrelevant", local: nil, remote: &blitz, peerSupportsClock: false, want: blitz},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := negotiateTimeControl(c.local, c.remote, c.peerSupportsClock)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func newTestClockMatch(tc TimeControl, white, black peer.ID) *Match {
+	return &Match{
+		info:           MatchInfo{White: white, Black: black, TimeControl: tc},
+		whiteRemaining: tc.Base,
+		blackRemaining: tc.Base,
+		turn:           white,
+		turnStartedAt:  time.Now(),
+	}
+}
+
+func TestRecordMoveDeductsElapsedAddsIncrementAndPassesTurn(t *testing.T) {
+	white := peer.ID("white")
+	black := peer.ID("black")
+	tc := TimeControl{Kind: TimeControlFischer, Base: time.Minute, Increment: 2 * time.Second}
+	m := newTestClockMatch(tc, white, black)
+	m.turnStartedAt = time.Now().Add(-10 * time.Second)
+
+	// Comfortably under what 10s elapsed plus the increment allow, so the
+	// check can't flake on scheduling jitter between turnStartedAt and now.
+	reported := tc.Base - 11*time.Second
+
+	if err := m.recordMove(white, reported, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	white_, black_, turn := m.ClockState()
+	if turn != black {
+		t.Fatalf("turn = %s, want black", turn)
+	}
+	// ClockState projects the time black has spent on its own turn so far,
+	// so check black's recorded clock rather than that projection.
+	if m.blackRemaining != tc.Base {
+		t.Fatalf("black's clock should be untouched, got %s", m.blackRemaining)
+	}
+	if white_ != reported {
+		t.Fatalf("white's clock = %s, want %s", white_, reported)
+	}
+}
+
+func TestRecordMoveRejectsImplausibleRemaining(t *testing.T) {
+	white := peer.ID("white")
+	black := peer.ID("black")
+	tc := TimeControl{Kind: TimeControlBaseIncrement, Base: time.Minute, Increment: time.Second}
+	m := newTestClockMatch(tc, white, black)
+	m.turnStartedAt = time.Now().Add(-10 * time.Second)
+
+	// 10s actually elapsed, but white claims its full base time back: far
+	// more than elapsed+increment allow.
+	err := m.recordMove(white, tc.Base, time.Now())
+	if err != errImplausibleClockReading {
+		t.Fatalf("got error %v, want errImplausibleClockReading", err)
+	}
+}
+
+func TestRecordMoveRejectsNegativeRemaining(t *testing.T) {
+	white := peer.ID("white")
+	black := peer.ID("black")
+	tc := TimeControl{Kind: TimeControlBaseIncrement, Base: time.Minute, Increment: time.Second}
+	m := newTestClockMatch(tc, white, black)
+
+	if err := m.recordMove(white, -time.Second, time.Now()); err != errFlagFallen {
+		t.Fatalf("got error %v, want errFlagFallen", err)
+	}
+}
+
+func TestRecordMoveIgnoresUntrustedElapsedFromMover(t *testing.T) {
+	// Regression test: recordMove must bound elapsed using its own clock
+	// argument, not let a mover's self-reported state make its clock appear
+	// to never deplete. A mover claiming almost all of its time back despite
+	// a large real gap since turnStartedAt must be rejected.
+	white := peer.ID("white")
+	black := peer.ID("black")
+	tc := TimeControl{Kind: TimeControlFischer, Base: time.Minute, Increment: time.Second}
+	m := newTestClockMatch(tc, white, black)
+	m.turnStartedAt = time.Now().Add(-30 * time.Second)
+
+	err := m.recordMove(white, tc.Base-time.Millisecond, time.Now())
+	if err != errImplausibleClockReading {
+		t.Fatalf("got error %v, want errImplausibleClockReading", err)
+	}
+}
+
+func TestIncrementForLockedBronsteinCapsAtIncrement(t *testing.T) {
+	tc := TimeControl{Kind: TimeControlBronstein, Base: time.Minute, Increment: 5 * time.Second}
+	m := &Match{info: MatchInfo{TimeControl: tc}}
+
+	if got := m.incrementForLocked(2 * time.Second); got != 2*time.Second {
+		t.Fatalf("incrementForLocked(2s) = %s, want 2s", got)
+	}
+	if got := m.incrementForLocked(10 * time.Second); got != 5*time.Second {
+		t.Fatalf("incrementForLocked(10s) = %s, want 5s (capped at Increment)", got)
+	}
+}
+
+func TestIncrementForLockedUntimedMatchCreditsNothing(t *testing.T) {
+	m := &Match{info: MatchInfo{TimeControl: TimeControl{}}}
+	if got := m.incrementForLocked(time.Hour); got != 0 {
+		t.Fatalf("incrementForLocked on an untimed match = %s, want 0", got)
+	}
+}
@@ -0,0 +1,33 @@
+package p2p
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerCtxKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger, retrievable later with
+// LoggerFromContext. This lets a logger built up with keys like peerID or
+// matchID at a stream-handler or RPC boundary flow down through every call
+// that ctx reaches, instead of each subsystem wiring its own ad hoc
+// zap.String calls.
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// LoggerFromContext returns the logger attached to ctx via WithLogger, or
+// zap.NewNop() if none was attached.
+func LoggerFromContext(ctx context.Context) *zap.Logger {
+	logger, _ := loggerFromContext(ctx)
+	return logger
+}
+
+func loggerFromContext(ctx context.Context) (*zap.Logger, bool) {
+	logger, ok := ctx.Value(loggerCtxKey{}).(*zap.Logger)
+	if !ok {
+		return zap.NewNop(), false
+	}
+	return logger, true
+}
@@ -2,7 +2,6 @@ package p2p
 
 import (
 	"context"
-	"encoding/hex"
 	"sync"
 	"time"
 
@@ -11,17 +10,39 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"go.uber.org/zap"
 )
 
 type HostOption func(*Host)
 
-func WithLogger(logger *zap.Logger) HostOption {
+// WithHostLogger sets the base logger a Host derives all of its
+// context-scoped loggers from. Not to be confused with the package-level
+// WithLogger, which attaches a logger to a context.
+func WithHostLogger(logger *zap.Logger) HostOption {
 	return func(h *Host) {
 		h.logger = logger
 	}
 }
 
+// WithHostMaxMessageSize overrides the default maximum protobuf message
+// size, in bytes, a Host will send or accept on a stream when no
+// per-protocol override applies. Not to be confused with the package-level
+// WithMaxMessageSize, which attaches an override to a context.
+func WithHostMaxMessageSize(size int) HostOption {
+	return func(h *Host) {
+		h.maxMessageSize = size
+	}
+}
+
+// WithHostProtocolMaxMessageSize overrides the maximum message size for a
+// specific sub-protocol, e.g. a larger limit for spectator history dumps.
+func WithHostProtocolMaxMessageSize(protocolID string, size int) HostOption {
+	return func(h *Host) {
+		h.protocolMaxMessageSizes[protocolID] = size
+	}
+}
+
 // Host is responsible for handling the protocol steps with peers.
 //
 // thread-safe.
@@ -30,17 +51,36 @@ type Host struct {
 
 	p2pHost host.Host
 	kadDHT  *dht.IpfsDHT
+	pubSub  *pubsub.PubSub
 
 	acceptChan chan *acceptInfo
 
+	matches map[MatchID]*Match
+
+	lobbyCacheLock sync.Mutex
+	lobbyCache     map[peer.ID]ChallengeOffer
+
+	capabilitiesLock   sync.RWMutex
+	capabilities       map[peer.ID]Capabilities
+	capabilityWatchers map[chan CapabilityChange]struct{}
+
+	maxMessageSize          int
+	protocolMaxMessageSizes map[string]int
+
 	logger *zap.Logger
 }
 
 // NewHost creates a new host which can be started later.
 func NewHost(options ...HostOption) *Host {
 	h := &Host{
-		logger:     zap.NewNop(),
-		acceptChan: make(chan *acceptInfo),
+		logger:                  zap.NewNop(),
+		acceptChan:              make(chan *acceptInfo),
+		matches:                 make(map[MatchID]*Match),
+		lobbyCache:              make(map[peer.ID]ChallengeOffer),
+		capabilities:            make(map[peer.ID]Capabilities),
+		capabilityWatchers:      make(map[chan CapabilityChange]struct{}),
+		maxMessageSize:          defaultMaxMessageSize,
+		protocolMaxMessageSizes: make(map[string]int),
 	}
 
 	for _, option := range options {
@@ -78,6 +118,8 @@ func (h *Host) Start(ctx context.Context) error {
 
 	h.p2pHost = p2pHost
 	h.p2pHost.SetStreamHandler(ipchessProtocolID, h.handleStream)
+	h.p2pHost.SetStreamHandler(ipchessSpectateProtocolID, h.handleSpectateStream)
+	h.p2pHost.SetStreamHandler(ipchessIdentifyPushProtocolID, h.handleCapabilityPushStream)
 
 	kadDHT, err := dht.New(
 		ctx,
@@ -93,6 +135,14 @@ func (h *Host) Start(ctx context.Context) error {
 	}
 
 	h.kadDHT = kadDHT
+
+	pubSub, err := pubsub.NewGossipSub(ctx, h.p2pHost)
+	if err != nil {
+		return err
+	}
+	h.pubSub = pubSub
+	go h.watchLobby(ctx)
+
 	return nil
 }
 
@@ -109,12 +159,15 @@ func (h *Host) Connected() bool {
 }
 
 // Accept blocks until a challenge is accepted.
-// The host will decline incoming challenges that arrive while we are not accepting.
-func (h *Host) Accept(ctx context.Context) (*Match, error) {
+// The host will decline incoming challenges that arrive while we are not
+// accepting. If options specifies a TimeControl and the challenger requires
+// a different one, the challenge is declined with TimeControlMismatch.
+func (h *Host) Accept(ctx context.Context, options ...MatchOption) (*Match, error) {
 	ai := &acceptInfo{
-		Ctx:   ctx,
-		Match: make(chan *Match),
-		Err:   make(chan error),
+		Ctx:     ctx,
+		Options: newMatchOptions(options...),
+		Match:   make(chan *Match),
+		Err:     make(chan error),
 	}
 	h.acceptChan <- ai
 
@@ -128,11 +181,17 @@ func (h *Host) Accept(ctx context.Context) (*Match, error) {
 	}
 }
 
-// ChallengePeer challenges a peer to a match.
-func (h *Host) ChallengePeer(ctx context.Context, peerID peer.ID) (*Match, error) {
+// ChallengePeer challenges a peer to a match. If options specifies a
+// TimeControl and the peer requires a different one, the challenge is
+// declined with TimeControlMismatch.
+func (h *Host) ChallengePeer(ctx context.Context, peerID peer.ID, options ...MatchOption) (*Match, error) {
+	opts := newMatchOptions(options...)
+
 	for {
 		if h.Connected() {
-			logger := h.logger.With(zap.String("peerID", peerID.Pretty()))
+			ctx = WithLogger(ctx, h.logger.With(zap.String("peerID", peerID.Pretty())))
+			logger := LoggerFromContext(ctx)
+
 			logger.Debug("looking for peer")
 			peerAddrInfo, err := h.kadDHT.FindPeer(ctx, peerID)
 			if err != nil {
@@ -145,16 +204,34 @@ func (h *Host) ChallengePeer(ctx context.Context, peerID peer.ID) (*Match, error
 			if err != nil {
 				return nil, err
 			}
+			ctx = WithLogger(ctx, logger.With(zap.String("stream", stream.ID()), zap.String("proto", string(stream.Protocol()))))
+			logger = LoggerFromContext(ctx)
 
-			c := newChallenge(logger)
-			matchInfo, err := c.Initiate(ctx, stream)
+			maxMessageSize := h.maxMessageSizeFor(string(stream.Protocol()))
+			ctx = WithMaxMessageSize(ctx, maxMessageSize)
+
+			logger.Debug("sending hello")
+			if err := sendHello(ctx, stream, uint32(maxMessageSize)); err != nil {
+				return nil, err
+			}
+			peerCaps, err := receiveHello(ctx, stream)
+			if err != nil {
+				return nil, err
+			}
+			logger.Debug("received peer hello", zap.Any("capabilities", peerCaps))
+			h.setPeerCapabilities(peerAddrInfo.ID, peerCaps)
+
+			c := newChallenge()
+			matchInfo, err := c.Initiate(ctx, stream, opts, peerCaps.HasFeature(clockFeature))
 			if err != nil {
 				return nil, err
 			}
 			logger.Debug("challenge accepted", zap.Any("matchInfo", matchInfo))
 
-			logger = logger.With(zap.String("matchID", hex.EncodeToString(matchInfo.ID[:])))
-			return newMatch(logger, stream, *matchInfo), nil
+			ctx = WithLogger(ctx, logger.With(zap.String("matchID", matchInfo.ID.Pretty())))
+			match := newMatch(ctx, stream, *matchInfo)
+			h.registerMatch(match)
+			return match, nil
 		}
 
 		select {
@@ -173,28 +250,92 @@ func (h *Host) handleStream(stream network.Stream) {
 			close(ai.Err)
 		}()
 
-		logger := h.logger.With(zap.String("peerID", stream.Conn().RemotePeer().Pretty()))
+		ctx := WithLogger(ai.Ctx, h.logger.With(
+			zap.String("peerID", stream.Conn().RemotePeer().Pretty()),
+			zap.String("stream", stream.ID()),
+			zap.String("proto", string(stream.Protocol())),
+		))
+		logger := LoggerFromContext(ctx)
 		logger.Debug("new peer stream")
 
-		c := newChallenge(logger)
-		matchInfo, err := c.Handle(ai.Ctx, stream)
+		maxMessageSize := h.maxMessageSizeFor(string(stream.Protocol()))
+		ctx = WithMaxMessageSize(ctx, maxMessageSize)
+
+		peerCaps, err := receiveHello(ctx, stream)
+		if err != nil {
+			ai.Err <- err
+			return
+		}
+		logger.Debug("received peer hello", zap.Any("capabilities", peerCaps))
+		h.setPeerCapabilities(stream.Conn().RemotePeer(), peerCaps)
+
+		logger.Debug("sending hello")
+		if err := sendHello(ctx, stream, uint32(maxMessageSize)); err != nil {
+			ai.Err <- err
+			return
+		}
+
+		c := newChallenge()
+		matchInfo, err := c.Handle(ctx, stream, ai.Options, peerCaps.HasFeature(clockFeature))
 		if err != nil {
 			ai.Err <- err
 			return
 		}
 		logger.Debug("challenge accepted", zap.Any("matchInfo", matchInfo))
 
-		logger = logger.With(zap.String("matchID", hex.EncodeToString(matchInfo.ID[:])))
-		ai.Match <- newMatch(logger, stream, *matchInfo)
+		ctx = WithLogger(ctx, logger.With(zap.String("matchID", matchInfo.ID.Pretty())))
+		match := newMatch(ctx, stream, *matchInfo)
+		h.registerMatch(match)
+		ai.Match <- match
 	default:
 		// close the stream since we are not accepting challenges
 		stream.Close()
 	}
 }
 
+// maxMessageSizeFor returns the configured maximum message size for
+// protocolID, falling back to the Host's general default if no
+// protocol-specific override was set.
+func (h *Host) maxMessageSizeFor(protocolID string) int {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	if size, ok := h.protocolMaxMessageSizes[protocolID]; ok {
+		return size
+	}
+	return h.maxMessageSize
+}
+
+// Match returns the currently ongoing match with the given ID, and whether
+// one was found, e.g. for an RPC handler to query its clock state.
+func (h *Host) Match(matchID MatchID) (*Match, bool) {
+	h.stateLock.RLock()
+	defer h.stateLock.RUnlock()
+
+	match, ok := h.matches[matchID]
+	return match, ok
+}
+
+// registerMatch makes match discoverable to spectators under its ID and
+// wires it to remove itself from the registry once it is closed.
+func (h *Host) registerMatch(match *Match) {
+	id := match.Info().ID
+
+	h.stateLock.Lock()
+	h.matches[id] = match
+	h.stateLock.Unlock()
+
+	match.onClose = func() {
+		h.stateLock.Lock()
+		defer h.stateLock.Unlock()
+		delete(h.matches, id)
+	}
+}
+
 // acceptInfo holds data for accepting challenges asynchronously.
 type acceptInfo struct {
-	Ctx   context.Context
-	Match chan *Match
-	Err   chan error
+	Ctx     context.Context
+	Options *matchOptions
+	Match   chan *Match
+	Err     chan error
 }
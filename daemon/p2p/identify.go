@@ -0,0 +1,217 @@
+package p2p
+
+import (
+	"context"
+	"ipchess/gen/ipchessproto"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.uber.org/zap"
+)
+
+// ipchessIdentifyPushProtocolID is the sub-protocol used to push an update
+// to a previously-exchanged Hello, e.g. when a locally-enabled feature
+// becomes available mid-session. Unlike the initial Hello, a push is a
+// one-shot message on its own stream rather than the first frame of a
+// longer-lived one.
+const ipchessIdentifyPushProtocolID = "ipchess/identify-push/0.1.0"
+
+// protocolVersion is advertised in Hello as the ipchess application
+// protocol version this Host speaks, independent of the libp2p protocol ID.
+const protocolVersion = "0.1.0"
+
+// agentString identifies the daemon implementation, mirroring the libp2p
+// identify protocol's agent version string.
+const agentString = "phessdaemon/0.1.0"
+
+// localFeatures lists the ipchess features this Host supports. Peers use
+// this, together with SupportedVariants, to gate functionality instead of
+// discovering it the hard way by getting an opaque error mid-match.
+var localFeatures = []string{"spectate", "lobby", clockFeature}
+
+// localSupportedVariants lists the chess variants this Host can play.
+var localSupportedVariants = []string{"standard"}
+
+// Capabilities describes what a peer told us it supports during its Hello
+// handshake (or a later push), and is what Host.PeerCapabilities hands back
+// to callers.
+type Capabilities struct {
+	Version           string
+	Features          []string
+	MaxMessageLength  uint32
+	AgentString       string
+	SupportedVariants []string
+}
+
+// HasFeature reports whether caps advertises the named feature.
+func (caps Capabilities) HasFeature(feature string) bool {
+	for _, f := range caps.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// CapabilityChange is emitted on a Host's capability subscription whenever a
+// peer's advertised Capabilities are set or updated, whether from an
+// initial Hello or a later push.
+type CapabilityChange struct {
+	Peer         peer.ID
+	Capabilities Capabilities
+}
+
+func capabilitiesFromHello(hello *ipchessproto.Hello) Capabilities {
+	return Capabilities{
+		Version:           hello.Version,
+		Features:          hello.Features,
+		MaxMessageLength:  hello.MaxMessageLength,
+		AgentString:       hello.AgentString,
+		SupportedVariants: hello.SupportedVariants,
+	}
+}
+
+// localHello builds the Hello this Host sends when opening or accepting an
+// ipchess stream, advertising maxMessageSize so the peer knows what we will
+// accept on it.
+func localHello(maxMessageSize uint32) *ipchessproto.Hello {
+	return &ipchessproto.Hello{
+		Version:           protocolVersion,
+		Features:          localFeatures,
+		MaxMessageLength:  maxMessageSize,
+		AgentString:       agentString,
+		SupportedVariants: localSupportedVariants,
+	}
+}
+
+// sendHello sends this Host's Hello as the first frame on stream.
+func sendHello(ctx context.Context, stream network.Stream, maxMessageSize uint32) error {
+	return sendMessage(ctx, stream, localHello(maxMessageSize))
+}
+
+// receiveHello reads the peer's Hello as the first frame on stream.
+func receiveHello(ctx context.Context, stream network.Stream) (Capabilities, error) {
+	var hello ipchessproto.Hello
+	if err := receiveMessage(ctx, stream, &hello); err != nil {
+		return Capabilities{}, err
+	}
+	return capabilitiesFromHello(&hello), nil
+}
+
+// PeerCapabilities returns the most recently known Capabilities for peerID,
+// populated by a Hello handshake or identify push, and whether any are
+// known at all.
+func (h *Host) PeerCapabilities(peerID peer.ID) (Capabilities, bool) {
+	h.capabilitiesLock.RLock()
+	defer h.capabilitiesLock.RUnlock()
+
+	caps, ok := h.capabilities[peerID]
+	return caps, ok
+}
+
+// SubscribeCapabilities returns a channel of CapabilityChange events, one
+// per peer Hello or identify push handled from here on, until ctx is done.
+// The channel buffers a single event; an event that arrives while it is
+// still full is dropped for this subscriber.
+func (h *Host) SubscribeCapabilities(ctx context.Context) <-chan CapabilityChange {
+	changeChan := make(chan CapabilityChange, 1)
+
+	h.capabilitiesLock.Lock()
+	h.capabilityWatchers[changeChan] = struct{}{}
+	h.capabilitiesLock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.capabilitiesLock.Lock()
+		delete(h.capabilityWatchers, changeChan)
+		h.capabilitiesLock.Unlock()
+		close(changeChan)
+	}()
+
+	return changeChan
+}
+
+// setPeerCapabilities records caps as peerID's current Capabilities and
+// notifies every active capability subscription. Subscribers that are not
+// keeping up are skipped rather than blocking the handshake or push that
+// triggered the update.
+func (h *Host) setPeerCapabilities(peerID peer.ID, caps Capabilities) {
+	h.capabilitiesLock.Lock()
+	h.capabilities[peerID] = caps
+	watchers := make([]chan CapabilityChange, 0, len(h.capabilityWatchers))
+	for watcher := range h.capabilityWatchers {
+		watchers = append(watchers, watcher)
+	}
+	h.capabilitiesLock.Unlock()
+
+	change := CapabilityChange{Peer: peerID, Capabilities: caps}
+	for _, watcher := range watchers {
+		select {
+		case watcher <- change:
+		default:
+		}
+	}
+}
+
+// PushCapabilities opens a one-shot identify-push stream to peerID
+// announcing that features are now additionally supported, e.g. because
+// the user just enabled spectating. It does not replace the peer's other
+// previously-known features.
+func (h *Host) PushCapabilities(ctx context.Context, peerID peer.ID, features []string) error {
+	h.stateLock.RLock()
+	p2pHost := h.p2pHost
+	h.stateLock.RUnlock()
+
+	stream, err := p2pHost.NewStream(ctx, peerID, ipchessIdentifyPushProtocolID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	push := &ipchessproto.CapabilityPush{Features: features}
+	return sendMessage(ctx, stream, push)
+}
+
+// handleCapabilityPushStream reads a single CapabilityPush and merges its
+// features into whatever Capabilities we already know for the sender.
+func (h *Host) handleCapabilityPushStream(stream network.Stream) {
+	defer stream.Close()
+
+	peerID := stream.Conn().RemotePeer()
+	ctx := WithLogger(context.Background(), h.logger.With(
+		zap.String("peerID", peerID.Pretty()),
+		zap.String("stream", stream.ID()),
+		zap.String("proto", string(stream.Protocol())),
+	))
+	logger := LoggerFromContext(ctx)
+
+	var push ipchessproto.CapabilityPush
+	if err := receiveMessage(ctx, stream, &push); err != nil {
+		logger.Debug("failed reading capability push", zap.Error(err))
+		return
+	}
+
+	caps, _ := h.PeerCapabilities(peerID)
+	caps.Features = mergeFeatures(caps.Features, push.Features)
+	logger.Debug("received capability push", zap.Strings("features", push.Features))
+	h.setPeerCapabilities(peerID, caps)
+}
+
+// mergeFeatures returns existing with any of added not already present
+// appended to it.
+func mergeFeatures(existing, added []string) []string {
+	merged := existing
+	for _, feature := range added {
+		found := false
+		for _, have := range merged {
+			if have == feature {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, feature)
+		}
+	}
+	return merged
+}
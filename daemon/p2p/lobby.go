@@ -0,0 +1,231 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"ipchess/gen/ipchessproto"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// lobbyTopic is the well-known gossipsub topic peers announce open
+// challenges on, giving the daemon a matchmaking surface that does not
+// require already knowing a peer's ID.
+const lobbyTopic = "ipchess/lobby/v1"
+
+var (
+	errInvalidChallengeOfferSignature = errors.New("received invalid open challenge offer signature")
+	errChallengeOfferExpired          = errors.New("received expired open challenge offer")
+)
+
+// ChallengeOffer is a signed, self-expiring announcement that a peer is
+// open to being challenged to a match.
+type ChallengeOffer struct {
+	Peer         peer.ID
+	TimeControls []string
+	RatingHint   int32
+	Nonce        []byte
+	ExpiresAt    time.Time
+}
+
+// PublishOpenChallenge signs offer with the host's private key and gossips
+// it on the lobby topic. Nonce is filled in with fresh random bytes and
+// ExpiresAt defaults to one minute out if unset, so callers only need to
+// re-publish periodically to keep their offer alive.
+func (h *Host) PublishOpenChallenge(ctx context.Context, offer ChallengeOffer) error {
+	h.stateLock.RLock()
+	pubSub := h.pubSub
+	p2pHost := h.p2pHost
+	h.stateLock.RUnlock()
+
+	if offer.ExpiresAt.IsZero() {
+		offer.ExpiresAt = time.Now().Add(time.Minute)
+	}
+	if len(offer.Nonce) == 0 {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		offer.Nonce = nonce
+	}
+
+	peerBytes, err := p2pHost.ID().Marshal()
+	if err != nil {
+		return err
+	}
+
+	signed := signedChallengeOfferBytes(peerBytes, offer)
+	sig, err := p2pHost.Peerstore().PrivKey(p2pHost.ID()).Sign(signed)
+	if err != nil {
+		return err
+	}
+
+	msg := &ipchessproto.OpenChallenge{
+		Peer:         peerBytes,
+		TimeControls: offer.TimeControls,
+		RatingHint:   offer.RatingHint,
+		Nonce:        offer.Nonce,
+		ExpiresAt:    offer.ExpiresAt.Unix(),
+		Signature:    sig,
+	}
+	msgBytes, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	topic, err := pubSub.Join(lobbyTopic)
+	if err != nil {
+		return err
+	}
+
+	return topic.Publish(ctx, msgBytes)
+}
+
+// ListOpenChallenges returns a snapshot of currently-live open challenges
+// the host has observed on the lobby topic, pruning any that have expired.
+func (h *Host) ListOpenChallenges() []ChallengeOffer {
+	h.lobbyCacheLock.Lock()
+	defer h.lobbyCacheLock.Unlock()
+
+	now := time.Now()
+	offers := make([]ChallengeOffer, 0, len(h.lobbyCache))
+	for p, offer := range h.lobbyCache {
+		if now.After(offer.ExpiresAt) {
+			delete(h.lobbyCache, p)
+			continue
+		}
+		offers = append(offers, offer)
+	}
+
+	return offers
+}
+
+// watchLobby keeps the host's open-challenge cache fresh for ListOpenChallenges
+// by running a long-lived lobby subscription in the background.
+func (h *Host) watchLobby(ctx context.Context) {
+	offerChan, err := h.SubscribeLobby(ctx)
+	if err != nil {
+		h.logger.Debug("failed starting lobby watch", zap.Error(err))
+		return
+	}
+
+	for offer := range offerChan {
+		h.lobbyCacheLock.Lock()
+		h.lobbyCache[offer.Peer] = offer
+		h.lobbyCacheLock.Unlock()
+	}
+}
+
+// SubscribeLobby subscribes to the lobby topic and returns a channel of
+// verified open challenges. Offers with an invalid signature or that have
+// already expired are silently dropped.
+func (h *Host) SubscribeLobby(ctx context.Context) (<-chan ChallengeOffer, error) {
+	h.stateLock.RLock()
+	pubSub := h.pubSub
+	h.stateLock.RUnlock()
+
+	topic, err := pubSub.Join(lobbyTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	logger, ok := loggerFromContext(ctx)
+	if !ok {
+		logger = h.logger
+	}
+
+	offerChan := make(chan ChallengeOffer)
+	go func() {
+		defer sub.Cancel()
+		defer close(offerChan)
+
+		for {
+			psMsg, err := sub.Next(ctx)
+			if err != nil {
+				logger.Debug("lobby subscription ended", zap.Error(err))
+				return
+			}
+
+			var msg ipchessproto.OpenChallenge
+			if err := proto.Unmarshal(psMsg.Data, &msg); err != nil {
+				logger.Debug("dropping malformed open challenge", zap.Error(err))
+				continue
+			}
+
+			offer, err := verifyChallengeOffer(&msg)
+			if err != nil {
+				logger.Debug("dropping open challenge", zap.Error(err))
+				continue
+			}
+
+			select {
+			case offerChan <- offer:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return offerChan, nil
+}
+
+// verifyChallengeOffer checks an OpenChallenge's signature and expiry,
+// returning the decoded ChallengeOffer if both hold.
+func verifyChallengeOffer(msg *ipchessproto.OpenChallenge) (ChallengeOffer, error) {
+	peerID, err := peer.IDFromBytes(msg.Peer)
+	if err != nil {
+		return ChallengeOffer{}, err
+	}
+
+	offer := ChallengeOffer{
+		Peer:         peerID,
+		TimeControls: msg.TimeControls,
+		RatingHint:   msg.RatingHint,
+		Nonce:        msg.Nonce,
+		ExpiresAt:    time.Unix(msg.ExpiresAt, 0),
+	}
+
+	if time.Now().After(offer.ExpiresAt) {
+		return ChallengeOffer{}, errChallengeOfferExpired
+	}
+
+	pub, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return ChallengeOffer{}, err
+	}
+
+	valid, err := pub.Verify(signedChallengeOfferBytes(msg.Peer, offer), msg.Signature)
+	if err != nil {
+		return ChallengeOffer{}, err
+	}
+	if !valid {
+		return ChallengeOffer{}, errInvalidChallengeOfferSignature
+	}
+
+	return offer, nil
+}
+
+// signedChallengeOfferBytes returns the canonical byte layout an open
+// challenge offer is signed over.
+func signedChallengeOfferBytes(peerBytes []byte, offer ChallengeOffer) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(peerBytes)
+	for _, tc := range offer.TimeControls {
+		buf.WriteString(tc)
+	}
+	binary.Write(buf, binary.BigEndian, offer.RatingHint)
+	buf.Write(offer.Nonce)
+	binary.Write(buf, binary.BigEndian, offer.ExpiresAt.Unix())
+	return buf.Bytes()
+}
@@ -7,10 +7,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"ipchess/gen/ipchessproto"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -28,6 +31,10 @@ type MatchInfo struct {
 	ID    MatchID
 	White peer.ID
 	Black peer.ID
+
+	// TimeControl is the clock negotiated during the challenge that
+	// created this match. The zero value means the match is untimed.
+	TimeControl TimeControl
 }
 
 // Move represents a move to be sent to and received from peers.
@@ -45,14 +52,71 @@ type Match struct {
 	info   MatchInfo
 	logger *zap.Logger
 	stream network.Stream
+	enc    encoder
+	dec    decoder
+
+	onClose func()
+
+	broadcastLock sync.Mutex
+	moveHistory   []*ipchessproto.Move
+	// spectators maps each attached spectator stream to a mutex serializing
+	// writes to it, since both the history replay in AddSpectator and the
+	// live fan-out in PublishMove can write to the same stream.
+	spectators map[network.Stream]*sync.Mutex
+
+	clockLock      sync.Mutex
+	whiteRemaining time.Duration
+	blackRemaining time.Duration
+	turn           peer.ID
+	turnStartedAt  time.Time
+}
+
+func newMatch(ctx context.Context, stream network.Stream, info MatchInfo) *Match {
+	maxMessageSize := maxMessageSizeFromContext(ctx)
+	m := &Match{
+		info:           info,
+		logger:         LoggerFromContext(ctx),
+		stream:         stream,
+		enc:            newEncoder(stream, maxMessageSize),
+		dec:            newDecoder(stream, maxMessageSize),
+		spectators:     make(map[network.Stream]*sync.Mutex),
+		whiteRemaining: info.TimeControl.Base,
+		blackRemaining: info.TimeControl.Base,
+		turn:           info.White,
+	}
+	if info.TimeControl.Kind != "" {
+		m.turnStartedAt = time.Now()
+	}
+	return m
+}
+
+// send applies ctx's deadline to the match stream and encodes message on
+// it, reusing the Match's own encoder instead of building one per call.
+func (m *Match) send(ctx context.Context, message proto.Message) error {
+	if err := m.stream.SetWriteDeadline(deadlineFromContext(ctx)); err != nil {
+		return err
+	}
+	if err := m.enc.Encode(message); err != nil {
+		return err
+	}
+
+	m.logger.Debug("sent message", zap.Int("size", proto.Size(message)))
+	return nil
 }
 
-func newMatch(logger *zap.Logger, stream network.Stream, info MatchInfo) *Match {
-	return &Match{
-		info:   info,
-		logger: logger,
-		stream: stream,
+// receive applies ctx's deadline to the match stream and decodes the next
+// message from it, reusing the Match's own decoder instead of building one
+// per call.
+func (m *Match) receive(ctx context.Context, message proto.Message) error {
+	if err := m.stream.SetReadDeadline(deadlineFromContext(ctx)); err != nil {
+		return err
 	}
+	if err := m.dec.Decode(message); err != nil {
+		return err
+	}
+
+	m.logger.Debug("received message", zap.Int("size", proto.Size(message)))
+	return nil
 }
 
 func (m *Match) Info() MatchInfo {
@@ -63,47 +127,96 @@ func (m *Match) SendMove(ctx context.Context, move Move) error {
 	whitePeerBytes, _ := m.info.White.Marshal()
 	blackPeerBytes, _ := m.info.Black.Marshal()
 
-	moveSigBytes := bytes.NewBuffer(nil)
-	moveSigBytes.Write(m.info.ID[:])
-	moveSigBytes.Write(whitePeerBytes)
-	moveSigBytes.Write(blackPeerBytes)
-	if err := binary.Write(moveSigBytes, binary.BigEndian, move); err != nil {
-		return err
-	}
-	if err := binary.Write(moveSigBytes, binary.BigEndian, move.SeqNum); err != nil {
-		return err
-	}
+	enc := uint32(move.FromRow) | (uint32(move.FromCol) << 8) | (uint32(move.ToRow) << 16) | (uint32(move.ToCol) << 24)
+
+	movedAt := time.Now()
+	remaining := m.localRemainingAfterMove()
 
-	sig, err := m.stream.Conn().LocalPrivateKey().Sign(moveSigBytes.Bytes())
+	sig, err := m.stream.Conn().LocalPrivateKey().Sign(signedMoveBytes(m.info.ID, whitePeerBytes, blackPeerBytes, enc, move.SeqNum, remaining, movedAt))
 	if err != nil {
 		return err
 	}
 
-	enc := uint32(move.FromRow) | (uint32(move.FromCol) << 8) | (uint32(move.ToRow) << 16) | (uint32(move.ToCol) << 24)
-
-	m.logger.Debug("sending signed move")
+	m.logger.With(zap.Uint32("seq", move.SeqNum)).Debug("sending signed move")
 	msg := &ipchessproto.Move{
-		MatchId:   m.info.ID[:],
-		White:     whitePeerBytes,
-		Black:     blackPeerBytes,
-		Enc:       enc,
-		Seq:       move.SeqNum,
-		Signature: sig,
-	}
-	if err := sendMessage(ctx, m.stream, msg); err != nil {
+		MatchId:         m.info.ID[:],
+		White:           whitePeerBytes,
+		Black:           blackPeerBytes,
+		Enc:             enc,
+		Seq:             move.SeqNum,
+		Signature:       sig,
+		RemainingMillis: remaining.Milliseconds(),
+		MovedAtUnixNano: movedAt.UnixNano(),
+	}
+	if err := m.send(ctx, msg); err != nil {
 		return err
 	}
 
+	if err := m.recordMove(m.stream.Conn().LocalPeer(), remaining, movedAt); err != nil {
+		return err
+	}
+
+	m.PublishMove(msg)
+
 	return nil
 }
 
 func (m *Match) ReceiveMove(ctx context.Context) (Move, error) {
 	m.logger.Debug("waiting signed move")
 	var moveMsg ipchessproto.Move
-	if err := receiveMessage(ctx, m.stream, &moveMsg); err != nil {
+	if err := m.receive(ctx, &moveMsg); err != nil {
+		return Move{}, err
+	}
+
+	dec, signedBytes := decodeMove(&moveMsg)
+
+	m.logger.With(zap.Uint32("seq", moveMsg.Seq)).Debug("verifying move signature")
+	valid, err := m.stream.Conn().RemotePublicKey().Verify(signedBytes, moveMsg.Signature)
+	if err != nil {
+		return Move{}, err
+	}
+	if !valid {
+		return Move{}, errInvalidMoveSignature
+	}
+
+	// recordMove is bounded by our own receipt time here, not moveMsg's
+	// self-reported MovedAtUnixNano: the sender's clock isn't trusted for
+	// anti-cheat purposes, only for display.
+	remaining := time.Duration(moveMsg.RemainingMillis) * time.Millisecond
+	if err := m.recordMove(m.stream.Conn().RemotePeer(), remaining, time.Now()); err != nil {
 		return Move{}, err
 	}
 
+	m.PublishMove(&moveMsg)
+
+	return dec, nil
+}
+
+// signedMoveBytes returns the canonical byte layout a move is signed over:
+// the match ID, both players' peer IDs, the move's encoded squares, its
+// sequence number, the mover's remaining clock time, and the wall-clock
+// time the move was made at as Unix nanoseconds, written field-by-field.
+// That timestamp is not a monotonic clock reading and is not checked to
+// increase from one move to the next. Earlier this was
+// produced by binary.Write on the Move struct directly, which serialized
+// whatever layout Go's reflection chose for its fields rather than one both
+// peers are guaranteed to agree on, and separately wrote SeqNum a second
+// time.
+func signedMoveBytes(matchID MatchID, whitePeerBytes, blackPeerBytes []byte, enc uint32, seq uint32, remaining time.Duration, movedAt time.Time) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(matchID[:])
+	buf.Write(whitePeerBytes)
+	buf.Write(blackPeerBytes)
+	binary.Write(buf, binary.BigEndian, enc)
+	binary.Write(buf, binary.BigEndian, seq)
+	binary.Write(buf, binary.BigEndian, remaining.Milliseconds())
+	binary.Write(buf, binary.BigEndian, movedAt.UnixNano())
+	return buf.Bytes()
+}
+
+// decodeMove decodes the wire ipchessproto.Move into the local Move
+// representation, along with the canonical byte layout that was signed over.
+func decodeMove(moveMsg *ipchessproto.Move) (Move, []byte) {
 	var dec Move
 	dec.FromRow = uint8(moveMsg.Enc & 0xff)
 	dec.FromCol = uint8((moveMsg.Enc >> 8) & 0xff)
@@ -111,29 +224,125 @@ func (m *Match) ReceiveMove(ctx context.Context) (Move, error) {
 	dec.ToCol = uint8((moveMsg.Enc >> 24) & 0xff)
 	dec.SeqNum = moveMsg.Seq
 
-	signedMoveBytes := bytes.NewBuffer(nil)
-	signedMoveBytes.Write(moveMsg.MatchId)
-	signedMoveBytes.Write(moveMsg.White)
-	signedMoveBytes.Write(moveMsg.Black)
-	if err := binary.Write(signedMoveBytes, binary.BigEndian, dec); err != nil {
-		return Move{}, err
+	var matchID MatchID
+	copy(matchID[:], moveMsg.MatchId)
+
+	remaining := time.Duration(moveMsg.RemainingMillis) * time.Millisecond
+	movedAt := time.Unix(0, moveMsg.MovedAtUnixNano)
+
+	return dec, signedMoveBytes(matchID, moveMsg.White, moveMsg.Black, moveMsg.Enc, moveMsg.Seq, remaining, movedAt)
+}
+
+// spectatorWriteTimeout bounds how long PublishMove will wait on any single
+// spectator's write before giving up on it. A spectator that never reads
+// must only ever be able to delay itself, never the players' own
+// SendMove/ReceiveMove calls that trigger a broadcast.
+const spectatorWriteTimeout = 5 * time.Second
+
+// PublishMove records msg in the match's history and mirrors it to every
+// registered spectator stream. It is called for both moves we send and
+// moves we receive, so spectators see exactly what the players see.
+//
+// Fan-out happens in its own goroutine per spectator, off the caller's
+// critical path, so a stalled or hostile spectator can never block the
+// match itself from exchanging further moves.
+func (m *Match) PublishMove(msg *ipchessproto.Move) {
+	m.broadcastLock.Lock()
+	m.moveHistory = append(m.moveHistory, msg)
+	streams := make(map[network.Stream]*sync.Mutex, len(m.spectators))
+	for stream, writeLock := range m.spectators {
+		streams[stream] = writeLock
 	}
-	if err := binary.Write(signedMoveBytes, binary.BigEndian, moveMsg.Seq); err != nil {
-		return Move{}, err
+	m.broadcastLock.Unlock()
+
+	for stream, writeLock := range streams {
+		go m.sendToSpectator(stream, writeLock, msg)
 	}
+}
 
-	m.logger.Debug("verifying move signature")
-	valid, err := m.stream.Conn().RemotePublicKey().Verify(signedMoveBytes.Bytes(), moveMsg.Signature)
-	if err != nil {
-		return Move{}, err
+// sendToSpectator writes msg to a single spectator stream, bounding the
+// write with its own deadline and dropping the spectator instead of
+// retrying if it fails or times out. writeLock serializes this write
+// against any history replay AddSpectator may still be doing on the same
+// stream.
+func (m *Match) sendToSpectator(stream network.Stream, writeLock *sync.Mutex, msg *ipchessproto.Move) {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), spectatorWriteTimeout)
+	defer cancel()
+
+	if err := sendMessage(ctx, stream, msg); err != nil {
+		m.logger.Debug("dropping spectator", zap.Error(err))
+		stream.Close()
+
+		m.broadcastLock.Lock()
+		delete(m.spectators, stream)
+		m.broadcastLock.Unlock()
 	}
-	if !valid {
-		return Move{}, errInvalidMoveSignature
+}
+
+// AddSpectator registers stream as a spectator of this match: it first
+// replays the move history accumulated so far, then blocks mirroring every
+// move published afterwards until the stream is closed.
+//
+// The stream is registered in m.spectators in the same critical section as
+// the history snapshot, before the (potentially slow) replay even starts,
+// so no move published after this point can be missed. writeLock is held
+// across both the registration and the replay, not just the replay: a
+// PublishMove racing the registration would otherwise spawn its own
+// sendToSpectator goroutine that could win writeLock and write a live move
+// before the replay does, handing the spectator history out of order.
+// Holding the lock from before registration forces any such goroutine to
+// wait until the replay has finished.
+func (m *Match) AddSpectator(stream network.Stream) error {
+	writeLock := &sync.Mutex{}
+	writeLock.Lock()
+
+	m.broadcastLock.Lock()
+	history := make([]*ipchessproto.Move, len(m.moveHistory))
+	copy(history, m.moveHistory)
+	m.spectators[stream] = writeLock
+	m.broadcastLock.Unlock()
+
+	for _, msg := range history {
+		if err := sendMessage(context.Background(), stream, msg); err != nil {
+			writeLock.Unlock()
+
+			m.broadcastLock.Lock()
+			delete(m.spectators, stream)
+			m.broadcastLock.Unlock()
+
+			return err
+		}
 	}
+	writeLock.Unlock()
 
-	return dec, nil
+	// Spectators never send anything on this stream, so any read result
+	// (including EOF) means it has gone away.
+	buf := make([]byte, 1)
+	_, err := stream.Read(buf)
+
+	m.broadcastLock.Lock()
+	delete(m.spectators, stream)
+	m.broadcastLock.Unlock()
+
+	return err
 }
 
+// Close closes the match's player stream and every attached spectator
+// stream, so neither AddSpectator's blocked read nor a remote
+// Spectator.Moves() call is left waiting on a close that never comes.
 func (m *Match) Close() {
 	m.stream.Close()
+
+	m.broadcastLock.Lock()
+	for stream := range m.spectators {
+		stream.Close()
+	}
+	m.broadcastLock.Unlock()
+
+	if m.onClose != nil {
+		m.onClose()
+	}
 }
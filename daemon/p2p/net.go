@@ -4,68 +4,168 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/network"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
-const maxMessageLength = 1024
+// defaultMaxMessageSize is the per-message size limit applied when neither
+// a Host nor a context override configures one. It is comfortably larger
+// than the original fixed 1 KiB limit so that spectator history replays
+// and future message types (PGN uploads, resync snapshots) are not capped
+// by a limit sized for the smallest messages.
+const defaultMaxMessageSize = 1 << 20
 
-var (
-	errMaxMessageLengthExceeded = errors.New("max message length exceeded")
-)
+var errMaxMessageSizeExceeded = errors.New("max message size exceeded")
 
-// sendMessage tries to send a length-prefixed protobuf encoded message.
-func sendMessage(ctx context.Context, stream network.Stream, message proto.Message) error {
+type maxMessageSizeCtxKey struct{}
+
+// WithMaxMessageSize returns a copy of ctx overriding the maximum message
+// size sendMessage/receiveMessage, and anything built on top of them like
+// Match and Spectator, will allow on the stream ctx was derived for.
+func WithMaxMessageSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, maxMessageSizeCtxKey{}, size)
+}
+
+func maxMessageSizeFromContext(ctx context.Context) int {
+	if size, ok := ctx.Value(maxMessageSizeCtxKey{}).(int); ok {
+		return size
+	}
+	return defaultMaxMessageSize
+}
+
+// deadlineFromContext returns ctx's deadline, or the zero time.Time if it
+// has none, which SetReadDeadline/SetWriteDeadline treat as "no deadline".
+func deadlineFromContext(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	return deadline
+}
+
+// encoder writes a stream of length-prefixed protobuf messages.
+type encoder interface {
+	Encode(message proto.Message) error
+}
+
+// decoder reads a stream of length-prefixed protobuf messages.
+type decoder interface {
+	Decode(message proto.Message) error
+}
+
+// streamEncoder is the encoder used for network.Stream-backed connections:
+// each message is framed with a varint length prefix ahead of its
+// protobuf encoding, so large messages no longer need to fit a fixed
+// 2-byte length field.
+type streamEncoder struct {
+	w       io.Writer
+	maxSize int
+}
+
+// newEncoder returns an encoder that writes messages to w, rejecting any
+// message whose encoded size exceeds maxSize before writing anything.
+func newEncoder(w io.Writer, maxSize int) encoder {
+	return &streamEncoder{w: w, maxSize: maxSize}
+}
+
+func (e *streamEncoder) Encode(message proto.Message) error {
 	msgBytes, err := proto.Marshal(message)
 	if err != nil {
 		return err
 	}
 
-	msgLength := uint16(len(msgBytes))
-	if msgLength > maxMessageLength {
-		return errMaxMessageLengthExceeded
+	if len(msgBytes) > e.maxSize {
+		return errMaxMessageSizeExceeded
 	}
 
-	ctxDeadline, _ := ctx.Deadline()
-	if err := stream.SetWriteDeadline(ctxDeadline); err != nil {
+	lengthPrefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lengthPrefix, uint64(len(msgBytes)))
+	if _, err := e.w.Write(lengthPrefix[:n]); err != nil {
 		return err
 	}
 
-	if err := binary.Write(stream, binary.BigEndian, msgLength); err != nil {
+	_, err = e.w.Write(msgBytes)
+	return err
+}
+
+// streamDecoder is the decoder used for network.Stream-backed connections.
+type streamDecoder struct {
+	r       io.Reader
+	maxSize int
+}
+
+// newDecoder returns a decoder that reads messages from r, rejecting any
+// message whose declared length exceeds maxSize before allocating a
+// buffer for it.
+func newDecoder(r io.Reader, maxSize int) decoder {
+	return &streamDecoder{r: r, maxSize: maxSize}
+}
+
+func (d *streamDecoder) Decode(message proto.Message) error {
+	length, err := binary.ReadUvarint(&byteReader{r: d.r})
+	if err != nil {
 		return err
 	}
-	if _, err := stream.Write(msgBytes); err != nil {
+
+	if length > uint64(d.maxSize) {
+		return errMaxMessageSizeExceeded
+	}
+
+	// stream.Read may return fewer bytes than requested even with no error,
+	// so read the full message with io.ReadFull rather than a single Read.
+	msgBytes := make([]byte, length)
+	if _, err := io.ReadFull(d.r, msgBytes); err != nil {
 		return err
 	}
 
-	return nil
+	return proto.Unmarshal(msgBytes, message)
 }
 
-// receiveMessage tries to receive a length-prefixed protobuf encoded message.
-func receiveMessage(ctx context.Context, stream network.Stream, message proto.Message) error {
-	ctxDeadline, _ := ctx.Deadline()
-	if err := stream.SetReadDeadline(ctxDeadline); err != nil {
-		return err
+// byteReader adapts an io.Reader to the io.ByteReader binary.ReadUvarint
+// requires, since network.Stream does not implement it directly.
+type byteReader struct {
+	r io.Reader
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
 	}
+	return b[0], nil
+}
 
-	var msgLength uint16
-	if err := binary.Read(stream, binary.BigEndian, &msgLength); err != nil {
+// sendMessage encodes and sends a single length-prefixed protobuf message
+// on stream, honoring ctx's deadline and maximum message size.
+func sendMessage(ctx context.Context, stream network.Stream, message proto.Message) error {
+	if err := stream.SetWriteDeadline(deadlineFromContext(ctx)); err != nil {
 		return err
 	}
 
-	if msgLength > maxMessageLength {
-		return errMaxMessageLengthExceeded
+	enc := newEncoder(stream, maxMessageSizeFromContext(ctx))
+	if err := enc.Encode(message); err != nil {
+		return err
 	}
 
-	msgBytes := make([]byte, msgLength)
-	if _, err := stream.Read(msgBytes); err != nil {
+	LoggerFromContext(ctx).Debug("sent message", zap.Int("size", proto.Size(message)))
+
+	return nil
+}
+
+// receiveMessage receives and decodes a single length-prefixed protobuf
+// message from stream, honoring ctx's deadline and maximum message size.
+func receiveMessage(ctx context.Context, stream network.Stream, message proto.Message) error {
+	if err := stream.SetReadDeadline(deadlineFromContext(ctx)); err != nil {
 		return err
 	}
 
-	if err := proto.Unmarshal(msgBytes, message); err != nil {
+	dec := newDecoder(stream, maxMessageSizeFromContext(ctx))
+	if err := dec.Decode(message); err != nil {
 		return err
 	}
 
+	LoggerFromContext(ctx).Debug("received message", zap.Int("size", proto.Size(message)))
+
 	return nil
 }
@@ -0,0 +1,92 @@
+package p2p
+
+import (
+	"bytes"
+	"ipchess/gen/ipchessproto"
+	"testing"
+)
+
+func TestStreamEncoderDecoderRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := newEncoder(buf, defaultMaxMessageSize)
+	dec := newDecoder(buf, defaultMaxMessageSize)
+
+	sent := &ipchessproto.Hello{
+		Version:           "0.1.0",
+		Features:          []string{"spectate", "clock"},
+		MaxMessageLength:  4096,
+		AgentString:       "phessdaemon/0.1.0",
+		SupportedVariants: []string{"standard"},
+	}
+	if err := enc.Encode(sent); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var received ipchessproto.Hello
+	if err := dec.Decode(&received); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if received.Version != sent.Version || received.MaxMessageLength != sent.MaxMessageLength || received.AgentString != sent.AgentString {
+		t.Fatalf("decoded message does not match sent message: got %+v, want %+v", received, sent)
+	}
+	if len(received.Features) != len(sent.Features) || received.Features[1] != sent.Features[1] {
+		t.Fatalf("decoded Features does not match sent Features: got %v, want %v", received.Features, sent.Features)
+	}
+}
+
+func TestStreamEncoderRejectsOversizeMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := newEncoder(buf, 1)
+
+	err := enc.Encode(&ipchessproto.Hello{Version: "0.1.0"})
+	if err != errMaxMessageSizeExceeded {
+		t.Fatalf("got error %v, want errMaxMessageSizeExceeded", err)
+	}
+}
+
+func TestStreamDecoderRejectsOversizeMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := newEncoder(buf, defaultMaxMessageSize).Encode(&ipchessproto.Hello{Version: "0.1.0"}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	dec := newDecoder(buf, 1)
+	var received ipchessproto.Hello
+	if err := dec.Decode(&received); err != errMaxMessageSizeExceeded {
+		t.Fatalf("got error %v, want errMaxMessageSizeExceeded", err)
+	}
+}
+
+func TestStreamDecoderShortRead(t *testing.T) {
+	// A reader that only ever yields its bytes one at a time exercises the
+	// io.ReadFull path: a single stream.Read is not guaranteed to return the
+	// whole message, so a decoder relying on one Read per message would
+	// truncate this.
+	buf := &bytes.Buffer{}
+	if err := newEncoder(buf, defaultMaxMessageSize).Encode(&ipchessproto.Hello{Version: "0.1.0", AgentString: "phessdaemon/0.1.0"}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	dec := newDecoder(&oneByteAtATimeReader{r: buf}, defaultMaxMessageSize)
+	var received ipchessproto.Hello
+	if err := dec.Decode(&received); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if received.Version != "0.1.0" || received.AgentString != "phessdaemon/0.1.0" {
+		t.Fatalf("decoded message truncated: got %+v", received)
+	}
+}
+
+// oneByteAtATimeReader wraps an io.Reader, handing back at most one byte per
+// Read call regardless of how large the caller's buffer is.
+type oneByteAtATimeReader struct {
+	r *bytes.Buffer
+}
+
+func (r *oneByteAtATimeReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
@@ -0,0 +1,198 @@
+package p2p
+
+import (
+	"context"
+	"ipchess/gen/ipchessproto"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.uber.org/zap"
+)
+
+// ipchessSpectateProtocolID is the sub-protocol spectators use to attach to
+// an ongoing match hosted by one of its players. It is receive-only: a
+// spectator stream never carries moves the other way.
+const ipchessSpectateProtocolID = "ipchess/spectate/0.1.0"
+
+// Spectator is a read-only view onto an ongoing match, opened against one of
+// its players. Every move it receives is the same signed ipchessproto.Move
+// exchanged between the players, so it can be verified independently
+// without trusting the relaying peer.
+type Spectator struct {
+	logger  *zap.Logger
+	stream  network.Stream
+	dec     decoder
+	matchID MatchID
+}
+
+func newSpectator(logger *zap.Logger, stream network.Stream, matchID MatchID, maxMessageSize int) *Spectator {
+	return &Spectator{
+		logger:  logger,
+		stream:  stream,
+		dec:     newDecoder(stream, maxMessageSize),
+		matchID: matchID,
+	}
+}
+
+// Moves streams the spectated match's move history followed by every move
+// sent afterwards, until ctx is done or the host closes the stream.
+func (s *Spectator) Moves(ctx context.Context) (<-chan Move, <-chan error) {
+	moveChan := make(chan Move)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(moveChan)
+
+		for {
+			if err := s.stream.SetReadDeadline(deadlineFromContext(ctx)); err != nil {
+				errChan <- err
+				return
+			}
+
+			var moveMsg ipchessproto.Move
+			if err := s.dec.Decode(&moveMsg); err != nil {
+				errChan <- err
+				return
+			}
+
+			move, err := verifySpectatedMove(&moveMsg)
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			select {
+			case moveChan <- move:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return moveChan, errChan
+}
+
+// Close stops spectating and closes the underlying stream.
+func (s *Spectator) Close() {
+	s.stream.Close()
+}
+
+// verifySpectatedMove decodes msg and checks its signature against whichever
+// of the match's two players signed it, since a spectator stream mixes moves
+// from both sides and, unlike a player's own Match.ReceiveMove, has no single
+// "remote peer" to check against.
+func verifySpectatedMove(moveMsg *ipchessproto.Move) (Move, error) {
+	dec, signedBytes := decodeMove(moveMsg)
+
+	whiteID, err := peer.IDFromBytes(moveMsg.White)
+	if err != nil {
+		return Move{}, err
+	}
+	blackID, err := peer.IDFromBytes(moveMsg.Black)
+	if err != nil {
+		return Move{}, err
+	}
+
+	whitePub, err := whiteID.ExtractPublicKey()
+	if err != nil {
+		return Move{}, err
+	}
+	blackPub, err := blackID.ExtractPublicKey()
+	if err != nil {
+		return Move{}, err
+	}
+
+	validWhite, _ := whitePub.Verify(signedBytes, moveMsg.Signature)
+	validBlack, _ := blackPub.Verify(signedBytes, moveMsg.Signature)
+	if !validWhite && !validBlack {
+		return Move{}, errInvalidMoveSignature
+	}
+
+	return dec, nil
+}
+
+// Spectate discovers player via the DHT and opens a spectator stream for
+// matchID, replaying its history and then following it live. It retries
+// until the host has DHT connectivity, the same way ChallengePeer does.
+func (h *Host) Spectate(ctx context.Context, matchID MatchID, player peer.ID) (*Spectator, error) {
+	for {
+		if h.Connected() {
+			ctx = WithLogger(ctx, h.logger.With(
+				zap.String("peerID", player.Pretty()),
+				zap.String("matchID", matchID.Pretty()),
+			))
+			logger := LoggerFromContext(ctx)
+
+			logger.Debug("looking for peer to spectate")
+			peerAddrInfo, err := h.kadDHT.FindPeer(ctx, player)
+			if err != nil {
+				return nil, err
+			}
+
+			stream, err := h.p2pHost.NewStream(ctx, peerAddrInfo.ID, ipchessSpectateProtocolID)
+			if err != nil {
+				return nil, err
+			}
+
+			maxMessageSize := h.maxMessageSizeFor(string(stream.Protocol()))
+			ctx = WithMaxMessageSize(ctx, maxMessageSize)
+
+			logger.Debug("sending spectate request")
+			req := &ipchessproto.SpectateRequest{MatchId: matchID[:]}
+			if err := sendMessage(ctx, stream, req); err != nil {
+				stream.Close()
+				return nil, err
+			}
+
+			return newSpectator(logger, stream, matchID, maxMessageSize), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// handleSpectateStream attaches an incoming spectator to the match it asks
+// for, if we are currently hosting one with that ID.
+func (h *Host) handleSpectateStream(stream network.Stream) {
+	ctx := WithLogger(context.Background(), h.logger.With(
+		zap.String("peerID", stream.Conn().RemotePeer().Pretty()),
+		zap.String("stream", stream.ID()),
+		zap.String("proto", string(stream.Protocol())),
+	))
+	logger := LoggerFromContext(ctx)
+
+	ctx = WithMaxMessageSize(ctx, h.maxMessageSizeFor(string(stream.Protocol())))
+
+	var req ipchessproto.SpectateRequest
+	if err := receiveMessage(ctx, stream, &req); err != nil {
+		logger.Debug("failed reading spectate request", zap.Error(err))
+		stream.Close()
+		return
+	}
+
+	var matchID MatchID
+	copy(matchID[:], req.MatchId)
+	logger = logger.With(zap.String("matchID", matchID.Pretty()))
+
+	h.stateLock.RLock()
+	match, ok := h.matches[matchID]
+	h.stateLock.RUnlock()
+
+	if !ok {
+		logger.Debug("spectate requested for unknown match")
+		stream.Close()
+		return
+	}
+
+	logger.Debug("new spectator")
+	if err := match.AddSpectator(stream); err != nil {
+		logger.Debug("spectator stream ended", zap.Error(err))
+	}
+	stream.Close()
+}